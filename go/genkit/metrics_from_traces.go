@@ -0,0 +1,198 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func spanStatusCodeAttr(code codes.Code) attribute.KeyValue {
+	return attribute.String("status_code", code.String())
+}
+
+func displayNameAttr(name string) attribute.KeyValue {
+	return attribute.String("display_name", name)
+}
+
+// metricsFromTraces is a [go.opentelemetry.io/otel/sdk/trace.SpanExporter]
+// that derives RED-style metrics (rate, errors, duration) from the spans it
+// sees. It is registered as its own exporter via sdktrace.WithBatcher,
+// alongside newTraceStoreExporter and/or NewOTLPExporter, the same way
+// multiple exporters are composed in this package. By default it does not
+// wrap or replace them; WithNextExporter makes it forward each batch to
+// another exporter.
+type metricsFromTraces struct {
+	next sdktrace.SpanExporter
+
+	rootDuration      metric.Float64Histogram
+	spansByStatus     metric.Int64Counter
+	droppedAttributes metric.Int64Counter
+	droppedEvents     metric.Int64Counter
+	queueDepth        metric.Int64ObservableGauge
+}
+
+// MetricsFromTracesOption configures [NewMetricsFromTraces].
+type MetricsFromTracesOption func(*metricsFromTracesOptions)
+
+type metricsFromTracesOptions struct {
+	queueDepthFunc func() int64
+	next           sdktrace.SpanExporter
+}
+
+// WithQueueDepthFunc reports the value returned by f as the
+// genkit.trace.queue_depth gauge, calling f each time the gauge is
+// collected. f is supplied by the caller; this package does not read the
+// span processor's queue itself. Without this option no queue-depth gauge
+// is registered.
+func WithQueueDepthFunc(f func() int64) MetricsFromTracesOption {
+	return func(o *metricsFromTracesOptions) { o.queueDepthFunc = f }
+}
+
+// WithNextExporter chains next after metrics are recorded for a batch,
+// instead of the default no-op. Use this only if you want a single
+// sdktrace.WithBatcher registration to both emit metrics and export spans;
+// otherwise prefer registering NewMetricsFromTraces's result and your
+// TraceStore/OTLP exporter as independent batchers.
+func WithNextExporter(next sdktrace.SpanExporter) MetricsFromTracesOption {
+	return func(o *metricsFromTracesOptions) { o.next = next }
+}
+
+// NewMetricsFromTraces returns a [go.opentelemetry.io/otel/sdk/trace.SpanExporter]
+// that, as it walks each batch of exported spans, updates meterProvider
+// with RED-style metrics: a histogram of root-span durations bucketed by
+// DisplayName, a counter of spans by Status.Code, counters of span
+// attributes/events dropped by the OTel SDK's own span limits, and
+// (if configured via WithQueueDepthFunc) a gauge for the span processor's
+// queue depth. This lets users get latency/error/throughput dashboards for
+// every flow and action without standing up a separate metrics pipeline.
+//
+// Register it the same way NewOTLPExporter is registered: pass its result
+// to a second sdktrace.WithBatcher call alongside whichever exporter(s)
+// actually persist spans. There is no genkit.Init in this module to do
+// that wiring automatically; callers own the sdktrace.TracerProvider setup.
+//
+// The dropped-attribute/event counters reflect drops the OTel SDK itself
+// applied before this exporter ever saw the span (its own span-limits
+// config). They cannot reflect genkit's own SpanLimits (see
+// WithSpanLimits), since that truncation happens downstream inside
+// newTraceStoreExporter's convertSpan and is invisible to a sibling
+// exporter working from the raw ReadOnlySpan.
+func NewMetricsFromTraces(meterProvider metric.MeterProvider, opts ...MetricsFromTracesOption) (sdktrace.SpanExporter, error) {
+	o := metricsFromTracesOptions{next: noopSpanExporter{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	meter := meterProvider.Meter("genkit.io/trace-metrics")
+
+	rootDuration, err := meter.Float64Histogram(
+		"genkit.trace.root_span.duration",
+		metric.WithDescription("Duration of root spans (flows/actions), in milliseconds, by display name."),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewMetricsFromTraces: %w", err)
+	}
+	spansByStatus, err := meter.Int64Counter(
+		"genkit.trace.spans",
+		metric.WithDescription("Number of spans exported, by status code."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewMetricsFromTraces: %w", err)
+	}
+	droppedAttributes, err := meter.Int64Counter(
+		"genkit.trace.dropped_attributes",
+		metric.WithDescription("Number of span attributes dropped by the OTel SDK's span limits before export."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewMetricsFromTraces: %w", err)
+	}
+	droppedEvents, err := meter.Int64Counter(
+		"genkit.trace.dropped_events",
+		metric.WithDescription("Number of span events dropped by the OTel SDK's span limits before export."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewMetricsFromTraces: %w", err)
+	}
+
+	m := &metricsFromTraces{
+		next:              o.next,
+		rootDuration:      rootDuration,
+		spansByStatus:     spansByStatus,
+		droppedAttributes: droppedAttributes,
+		droppedEvents:     droppedEvents,
+	}
+
+	if o.queueDepthFunc != nil {
+		queueDepth, err := meter.Int64ObservableGauge(
+			"genkit.trace.queue_depth",
+			metric.WithDescription("Depth of the span processor's export queue."),
+			metric.WithInt64Callback(func(_ context.Context, obs metric.Int64Observer) error {
+				obs.Observe(o.queueDepthFunc())
+				return nil
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("genkit.NewMetricsFromTraces: %w", err)
+		}
+		m.queueDepth = queueDepth
+	}
+
+	return m, nil
+}
+
+// ExportSpans implements [go.opentelemetry.io/otel/sdk/trace.SpanExporter.ExportSpans].
+// It records metrics for every span in the batch, then forwards the batch
+// to m.next (a no-op unless WithNextExporter was given).
+func (m *metricsFromTraces) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, span := range spans {
+		m.spansByStatus.Add(ctx, 1, metric.WithAttributes(
+			spanStatusCodeAttr(span.Status().Code),
+		))
+		m.droppedAttributes.Add(ctx, int64(span.DroppedAttributes()))
+		m.droppedEvents.Add(ctx, int64(span.DroppedEvents()))
+
+		if p := span.Parent(); !p.HasSpanID() {
+			durationMS := float64(span.EndTime().Sub(span.StartTime())) / float64(1e6)
+			m.rootDuration.Record(ctx, durationMS, metric.WithAttributes(
+				displayNameAttr(span.Name()),
+			))
+		}
+	}
+	return m.next.ExportSpans(ctx, spans)
+}
+
+// Shutdown implements [go.opentelemetry.io/otel/sdk/trace.SpanExporter.Shutdown].
+func (m *metricsFromTraces) Shutdown(ctx context.Context) error {
+	return m.next.Shutdown(ctx)
+}
+
+// noopSpanExporter is the default "next" exporter for metricsFromTraces: it
+// discards every batch, since metricsFromTraces is meant to be registered
+// as its own independent sdktrace.WithBatcher exporter rather than as a
+// wrapper around another one.
+type noopSpanExporter struct{}
+
+func (noopSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return nil
+}
+
+func (noopSpanExporter) Shutdown(ctx context.Context) error { return nil }
@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// OTLPProtocol selects the wire protocol used to ship spans to an OTLP
+// collector.
+type OTLPProtocol int
+
+const (
+	// OTLPProtocolGRPC sends spans over OTLP/gRPC. This is the default.
+	OTLPProtocolGRPC OTLPProtocol = iota
+	// OTLPProtocolHTTP sends spans over OTLP/HTTP (protobuf-encoded).
+	OTLPProtocolHTTP
+)
+
+// OTLPExporterOptions configures [NewOTLPExporter].
+type OTLPExporterOptions struct {
+	// Endpoint is the host:port of the OTLP collector, for both protocols
+	// (no scheme or path).
+	// If empty, the client falls back to the OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable, matching the upstream exporters' behavior.
+	Endpoint string
+	// Protocol selects gRPC or HTTP transport. Defaults to OTLPProtocolGRPC.
+	Protocol OTLPProtocol
+	// Insecure disables TLS when talking to the collector. Useful for
+	// local collectors such as the Jaeger or Tempo dev containers.
+	Insecure bool
+}
+
+// NewOTLPExporter returns a [go.opentelemetry.io/otel/sdk/trace.SpanExporter]
+// that ships spans to an OTLP collector (Jaeger, Tempo, Grafana, Honeycomb,
+// etc.) instead of a genkit TraceStore. It can be registered alongside
+// [newTraceStoreExporter] via separate calls to sdktrace.WithBatcher, so the
+// same spans can be both persisted locally and forwarded to an observability
+// backend.
+//
+// The returned exporter delegates the ReadOnlySpan → tracepb.ResourceSpans
+// translation (grouping by Resource and InstrumentationLibrary, and
+// converting Status, Links, Events and Attributes) to the upstream
+// go.opentelemetry.io/otel/exporters/otlp/otlptrace client, so genkit does
+// not need to reimplement that mapping.
+func NewOTLPExporter(ctx context.Context, opts OTLPExporterOptions) (sdktrace.SpanExporter, error) {
+	client, err := newOTLPClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewOTLPExporter: %w", err)
+	}
+	exp, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("genkit.NewOTLPExporter: %w", err)
+	}
+	return exp, nil
+}
+
+func newOTLPClient(opts OTLPExporterOptions) (otlptrace.Client, error) {
+	switch opts.Protocol {
+	case OTLPProtocolHTTP:
+		httpOpts := []otlptracehttp.Option{}
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.NewClient(httpOpts...), nil
+	case OTLPProtocolGRPC:
+		grpcOpts := []otlptracegrpc.Option{}
+		if opts.Endpoint != "" {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.NewClient(grpcOpts...), nil
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %d", opts.Protocol)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"regexp"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// A Sampler decides whether a trace should be kept, based on its root
+// span. It runs after the trace's spans have been converted by
+// convertSpan (with any SpanLimits already applied) and before redaction,
+// so it sees the TraceData that would be saved, unredacted; it does not
+// see the raw OpenTelemetry spans. Returning false drops the whole trace
+// before it ever reaches the TraceStore.
+type Sampler interface {
+	// Sample reports whether td, whose root span is root, should be saved.
+	Sample(root *SpanData, td *TraceData) bool
+}
+
+// SamplerFunc adapts a function to a Sampler.
+type SamplerFunc func(root *SpanData, td *TraceData) bool
+
+// Sample implements Sampler.
+func (f SamplerFunc) Sample(root *SpanData, td *TraceData) bool { return f(root, td) }
+
+// alwaysSampler is the concrete type behind AlwaysSample. traceStoreExporter
+// type-switches on it to skip root-buffering entirely for the default,
+// keep-everything case: buffering changes when a trace is visible in the
+// TraceStore (only once its root span exports), which is only necessary
+// when a real sampling decision has to wait for the root.
+type alwaysSampler struct{}
+
+// Sample implements Sampler.
+func (alwaysSampler) Sample(root *SpanData, td *TraceData) bool { return true }
+
+// AlwaysSample is the default Sampler: it keeps every trace, preserving
+// the historical behavior of this exporter.
+func AlwaysSample() Sampler {
+	return alwaysSampler{}
+}
+
+// ErrorsAndRatioSampler keeps every trace whose root span has an error
+// status, and a Ratio fraction of the rest, selected deterministically by
+// trace ID so that repeated exports of the same trace agree.
+type ErrorsAndRatioSampler struct {
+	// Ratio is the fraction, in [0,1], of non-errored traces to keep.
+	Ratio float64
+}
+
+// Sample implements Sampler.
+func (s ErrorsAndRatioSampler) Sample(root *SpanData, td *TraceData) bool {
+	if root.Status.Code == uint32(codes.Error) {
+		return true
+	}
+	if s.Ratio <= 0 {
+		return false
+	}
+	if s.Ratio >= 1 {
+		return true
+	}
+	return traceIDToUnitFloat(root.TraceID) < s.Ratio
+}
+
+// traceIDToUnitFloat maps a trace ID hex string onto [0,1) deterministically,
+// so the same trace always samples the same way.
+func traceIDToUnitFloat(traceID string) float64 {
+	if len(traceID) < 8 {
+		return 0
+	}
+	var n uint32
+	for i := 0; i < 8; i++ {
+		n = n<<4 | uint32(hexNibble(traceID[i]))
+	}
+	return float64(n) / float64(1<<32)
+}
+
+func hexNibble(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	default:
+		return 0
+	}
+}
+
+// A Redactor rewrites or drops an attribute (or event-annotation
+// attribute) before it is written to the TraceStore. Implementations
+// return the value to keep, or nil to drop the key entirely.
+type Redactor interface {
+	// Redact returns the value to store for key, given its original val.
+	// Returning nil drops key from the attribute map.
+	Redact(key string, val any) any
+}
+
+// RedactorFunc adapts a function to a Redactor.
+type RedactorFunc func(key string, val any) any
+
+// Redact implements Redactor.
+func (f RedactorFunc) Redact(key string, val any) any { return f(key, val) }
+
+// redactorChain composes Redactors, applying them in order. A key dropped
+// by one redactor (its value becomes nil) is not passed to the rest.
+type redactorChain []Redactor
+
+// ChainRedactors composes multiple Redactors into one, applying them in
+// order. It lets sampling/redaction rules be layered independently, e.g. a
+// key-based dropper followed by a regex scrubber.
+func ChainRedactors(redactors ...Redactor) Redactor {
+	return redactorChain(redactors)
+}
+
+// Redact implements Redactor.
+func (c redactorChain) Redact(key string, val any) any {
+	for _, r := range c {
+		val = r.Redact(key, val)
+		if val == nil {
+			return nil
+		}
+	}
+	return val
+}
+
+// DropKeys returns a Redactor that drops any attribute whose key is in
+// keys, e.g. "genkit:input" or "genkit:output".
+func DropKeys(keys ...string) Redactor {
+	set := map[string]bool{}
+	for _, k := range keys {
+		set[k] = true
+	}
+	return RedactorFunc(func(key string, val any) any {
+		if set[key] {
+			return nil
+		}
+		return val
+	})
+}
+
+// RedactPattern returns a Redactor that replaces regex matches within
+// string attribute values with replacement, for every key, leaving
+// non-string values and values with no match untouched. It's meant for scrubbing secrets or PII
+// (API keys, emails, etc.) out of free-form attributes like
+// "genkit:input"/"genkit:output".
+func RedactPattern(pattern *regexp.Regexp, replacement string) Redactor {
+	return RedactorFunc(func(key string, val any) any {
+		s, ok := val.(string)
+		if !ok {
+			return val
+		}
+		return pattern.ReplaceAllString(s, replacement)
+	})
+}
+
+// redactAttributes applies r to every key/value in attrs, in place,
+// deleting keys whose redacted value is nil.
+func redactAttributes(attrs map[string]any, r Redactor) {
+	if r == nil {
+		return
+	}
+	for k, v := range attrs {
+		if nv := r.Redact(k, v); nv == nil {
+			delete(attrs, k)
+		} else {
+			attrs[k] = nv
+		}
+	}
+}
+
+// redactTrace applies r to every span's attributes and event annotations
+// in td, in place.
+func redactTrace(td *TraceData, r Redactor) {
+	if r == nil {
+		return
+	}
+	for _, sd := range td.Spans {
+		redactAttributes(sd.Attributes, r)
+		for i := range sd.TimeEvents.TimeEvent {
+			redactAttributes(sd.TimeEvents.TimeEvent[i].Annotation.Attributes, r)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestErrorsAndRatioSampler(t *testing.T) {
+	errorRoot := &SpanData{
+		TraceID: "00000000000000000000000000000000",
+		Status:  Status{Code: uint32(codes.Error)},
+	}
+	// Trace ID chosen so its first 8 hex chars (0x00000000) map to the
+	// very bottom of the [0,1) range: it would sample in even at a tiny
+	// ratio, so it isolates the "errors always sample" behavior from the
+	// ratio behavior.
+	okRootLowID := &SpanData{
+		TraceID: "00000000000000000000000000000000",
+		Status:  Status{Code: uint32(codes.Ok)},
+	}
+	// Trace ID whose first 8 hex chars are 0xffffffff: maps to just under
+	// 1.0, so it only samples in when Ratio is (effectively) 1.
+	okRootHighID := &SpanData{
+		TraceID: "ffffffff000000000000000000000000",
+		Status:  Status{Code: uint32(codes.Ok)},
+	}
+	unsetRoot := &SpanData{
+		TraceID: "00000000000000000000000000000000",
+		Status:  Status{Code: uint32(codes.Unset)},
+	}
+
+	cases := []struct {
+		name  string
+		ratio float64
+		root  *SpanData
+		want  bool
+	}{
+		{"error status always kept regardless of ratio", 0, errorRoot, true},
+		{"ok status dropped at ratio zero", 0, okRootLowID, false},
+		{"ok status kept at ratio one", 1, okRootHighID, true},
+		{"unset status follows the ratio like ok", 0, unsetRoot, false},
+		{"low trace id samples in at a middling ratio", 0.5, okRootLowID, true},
+		{"high trace id samples out at a middling ratio", 0.5, okRootHighID, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := ErrorsAndRatioSampler{Ratio: c.ratio}
+			if got := s.Sample(c.root, &TraceData{}); got != c.want {
+				t.Errorf("Sample() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestChainRedactors(t *testing.T) {
+	redactor := ChainRedactors(
+		DropKeys("genkit:secret"),
+		RedactPattern(regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`), "[redacted-email]"),
+	)
+
+	cases := []struct {
+		key  string
+		val  any
+		want any
+	}{
+		{"genkit:secret", "sk-abc123", nil},
+		{"genkit:input", "contact jane@example.com for help", "contact [redacted-email] for help"},
+		{"genkit:other", 42, 42},
+	}
+	for _, c := range cases {
+		if got := redactor.Redact(c.key, c.val); got != c.want {
+			t.Errorf("Redact(%q, %v) = %v, want %v", c.key, c.val, got, c.want)
+		}
+	}
+}
+
+func TestRedactTrace_GoldenFixture(t *testing.T) {
+	in := &TraceData{
+		DisplayName: "myFlow",
+		Spans: map[string]*SpanData{
+			"root": {
+				SpanID:      "root",
+				DisplayName: "myFlow",
+				Attributes: map[string]any{
+					"genkit:secret": "sk-abc123",
+					"genkit:input":  "contact jane@example.com for help",
+				},
+			},
+			"child": {
+				SpanID:       "child",
+				ParentSpanID: "root",
+				DisplayName:  "generate",
+				Attributes: map[string]any{
+					"genkit:output": "reply to jane@example.com",
+					"genkit:model":  "gemini",
+				},
+			},
+		},
+	}
+	in.Spans["child"].TimeEvents.TimeEvent = []TimeEvent{
+		{
+			Annotation: annotation{
+				Description: "tool-call",
+				Attributes: map[string]any{
+					"genkit:secret": "sk-def456",
+				},
+			},
+		},
+	}
+
+	want := &TraceData{
+		DisplayName: "myFlow",
+		Spans: map[string]*SpanData{
+			"root": {
+				SpanID:      "root",
+				DisplayName: "myFlow",
+				Attributes: map[string]any{
+					"genkit:input": "contact [redacted-email] for help",
+				},
+			},
+			"child": {
+				SpanID:       "child",
+				ParentSpanID: "root",
+				DisplayName:  "generate",
+				Attributes: map[string]any{
+					"genkit:output": "reply to [redacted-email]",
+					"genkit:model":  "gemini",
+				},
+			},
+		},
+	}
+	want.Spans["child"].TimeEvents.TimeEvent = []TimeEvent{
+		{
+			Annotation: annotation{
+				Description: "tool-call",
+				Attributes:  map[string]any{},
+			},
+		},
+	}
+
+	redactor := ChainRedactors(
+		DropKeys("genkit:secret"),
+		RedactPattern(regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`), "[redacted-email]"),
+	)
+	redactTrace(in, redactor)
+
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("redactTrace() = %+v, want %+v", in, want)
+	}
+}
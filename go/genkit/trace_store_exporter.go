@@ -17,25 +17,170 @@ package genkit
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"go.opentelemetry.io/otel/attribute"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// SpanLimits bounds how much of a span's data the exporter will copy into
+// a SpanData, modeled on the OTel span-limits spec
+// (https://opentelemetry.io/docs/specs/otel/trace/sdk/#span-limits).
+// A zero value for any field means "no limit" for that field.
+type SpanLimits struct {
+	// MaxAttributeValueLength truncates attribute and event-annotation
+	// values longer than this many bytes.
+	MaxAttributeValueLength int
+	// MaxAttributesPerSpan caps the number of attributes kept per span
+	// (and per event annotation). Overflow attributes are dropped.
+	MaxAttributesPerSpan int
+	// MaxEventsPerSpan caps the number of time events kept per span.
+	MaxEventsPerSpan int
+	// MaxLinksPerSpan caps the number of links kept per span.
+	MaxLinksPerSpan int
+	// MaxSpanBytes caps the approximate serialized size of a span's
+	// attributes (name, IDs, and Attributes only — it does not account for
+	// events or links). If exceeded after all other limits are applied,
+	// attributes are dropped further (largest-first) until the attributes
+	// fit; use MaxEventsPerSpan/MaxLinksPerSpan to bound the rest.
+	MaxSpanBytes int
+}
+
+// DefaultSpanLimits returns the SpanLimits used when a traceStoreExporter
+// is not configured with WithSpanLimits: no limits are applied, preserving
+// the historical behavior of this exporter.
+func DefaultSpanLimits() SpanLimits {
+	return SpanLimits{}
+}
+
+const truncationMarker = "...[truncated %d bytes]"
+
+// A DeadLetterSink receives traces that a traceStoreExporter gave up on
+// after exhausting its retry budget. Implementations might log the trace,
+// write it to a file, or push it onto a queue for later inspection.
+type DeadLetterSink interface {
+	// Put records a trace, keyed by trace ID, that could not be saved.
+	Put(ctx context.Context, traceID string, td *TraceData, saveErr error)
+}
+
+// discardDeadLetterSink is the default DeadLetterSink: it drops traces on
+// the floor, preserving the historical behavior of this exporter.
+type discardDeadLetterSink struct{}
+
+func (discardDeadLetterSink) Put(ctx context.Context, traceID string, td *TraceData, saveErr error) {}
+
 // A traceStoreExporter is an OpenTelemetry SpanExporter that
 // writes spans to a TraceStore.
 type traceStoreExporter struct {
-	store TraceStore
+	store          TraceStore
+	deadLetterSink DeadLetterSink
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	spanLimits     SpanLimits
+	sampler        Sampler
+	redactor       Redactor
+	isRetryable    func(error) bool
+
+	// pendingMu guards pending and decisions, used only when e.sampler is
+	// something other than the default AlwaysSample. pending buffers spans
+	// for traces whose root span (the one Sample is keyed on) hasn't been
+	// exported yet. A trace's spans commonly arrive across several
+	// batches, since the root span — wrapping the whole flow — tends to
+	// finish, and so export, last. decisions remembers, once a trace's
+	// root has been seen and sampled, whether it was kept, so spans that
+	// arrive in later batches (after the root already exported) are
+	// routed to that same decision instead of re-buffering forever.
+	pendingMu sync.Mutex
+	pending   map[trace.TraceID][]sdktrace.ReadOnlySpan
+	decisions map[trace.TraceID]bool
+}
+
+// TraceStoreExporterOption configures a traceStoreExporter.
+type TraceStoreExporterOption func(*traceStoreExporter)
+
+// WithDeadLetterSink routes traces that fail every retry attempt to sink
+// instead of silently dropping them.
+func WithDeadLetterSink(sink DeadLetterSink) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.deadLetterSink = sink }
 }
 
-func newTraceStoreExporter(store TraceStore) *traceStoreExporter {
-	return &traceStoreExporter{store}
+// WithMaxRetries sets how many additional attempts are made to save a trace
+// after its first Save call fails. The default is 3.
+func WithMaxRetries(n int) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.maxRetries = n }
+}
+
+// WithBackoff sets the initial and maximum delay used by the exponential
+// backoff between retry attempts. The default is 100ms initial, 2s max.
+func WithBackoff(initial, max time.Duration) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) {
+		e.initialBackoff = initial
+		e.maxBackoff = max
+	}
+}
+
+// WithSpanLimits bounds how much attribute/event/link data is copied out
+// of each span and into the TraceStore. This keeps large LLM flows (big
+// prompt/response attributes, many tool-call events) from blowing up
+// TraceStore entries.
+func WithSpanLimits(limits SpanLimits) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.spanLimits = limits }
+}
+
+// WithSampler sets the Sampler used to decide whether a trace is kept at
+// all, based on its root span. The default is AlwaysSample.
+func WithSampler(sampler Sampler) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.sampler = sampler }
+}
+
+// WithRedactor sets the Redactor run over every remaining attribute and
+// event annotation before a trace is saved. Use [ChainRedactors] to
+// compose several redaction rules. The default applies no redaction.
+func WithRedactor(redactor Redactor) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.redactor = redactor }
+}
+
+// WithRetryableErrorFunc sets the predicate used to decide whether a Save
+// error is transient and worth retrying. Errors for which isRetryable
+// returns false are sent straight to the dead-letter sink without
+// consuming the retry/backoff budget. The default retries every error.
+func WithRetryableErrorFunc(isRetryable func(error) bool) TraceStoreExporterOption {
+	return func(e *traceStoreExporter) { e.isRetryable = isRetryable }
+}
+
+func newTraceStoreExporter(store TraceStore, opts ...TraceStoreExporterOption) *traceStoreExporter {
+	e := &traceStoreExporter{
+		store:          store,
+		deadLetterSink: discardDeadLetterSink{},
+		maxRetries:     3,
+		initialBackoff: 100 * time.Millisecond,
+		maxBackoff:     2 * time.Second,
+		spanLimits:     DefaultSpanLimits(),
+		sampler:        AlwaysSample(),
+		isRetryable:    func(error) bool { return true },
+		pending:        map[trace.TraceID][]sdktrace.ReadOnlySpan{},
+		decisions:      map[trace.TraceID]bool{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // ExportSpans implements [go.opentelemetry.io/otel/sdk/trace.SpanExporter.ExportSpans].
 // It saves the spans to e's TraceStore.
-// Saving is not atomic: it is possible that some but not all spans will be saved.
+//
+// A failing Save does not abandon the rest of the batch: each trace is
+// retried independently with exponential backoff, per-trace errors for
+// traces that exhaust their retries are collected into a single joined
+// error, and those traces are handed to e.deadLetterSink. If ctx's
+// deadline expires mid-batch, ExportSpans stops starting new saves and
+// returns, preserving whatever has already succeeded.
 func (e *traceStoreExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
 	// Group spans by trace ID.
 	spansByTrace := map[trace.TraceID][]sdktrace.ReadOnlySpan{}
@@ -44,28 +189,149 @@ func (e *traceStoreExporter) ExportSpans(ctx context.Context, spans []sdktrace.R
 		spansByTrace[tid] = append(spansByTrace[tid], span)
 	}
 
-	// Convert each trace to our types and save it.
-	for tid, spans := range spansByTrace {
+	var errs []error
+	for tid, newSpans := range spansByTrace {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			errs = append(errs, ctx.Err())
+			break
 		}
-		td, err := convertTrace(spans)
+		td, keep, err := e.convertAndSample(tid, newSpans)
 		if err != nil {
-			return err
+			errs = append(errs, err)
+			continue
+		}
+		if td == nil || !keep {
+			continue
+		}
+		redactTrace(td, e.redactor)
+		if err := e.saveWithRetry(ctx, tid.String(), td); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// convertAndSample converts newSpans into a TraceData ready to redact and
+// save, applying e.sampler's decision for tid. It returns td == nil when
+// there is nothing to save yet.
+//
+// With the default AlwaysSample, spans are converted and saved
+// immediately, batch by batch, exactly as before sampling/redaction was
+// introduced — there's no sampling decision to wait on, so traces still
+// show up in the TraceStore as soon as any of their spans export, not only
+// once the whole trace is complete.
+//
+// With any other Sampler, e.sampler is keyed on a trace's root span, but a
+// trace's spans routinely arrive split across multiple ExportSpans batches
+// (the root span, which wraps the whole flow, tends to finish and so
+// export last). Spans for a trace whose root hasn't been seen yet are
+// buffered in e.pending so the decision is never skipped just because a
+// given batch happened to be partial. Once the root is seen, the decision
+// is recorded in e.decisions so spans for the same trace that arrive in
+// still-later batches — after the root has already exported — are routed
+// to that same decision (saved or dropped) instead of being stranded in
+// e.pending until Shutdown.
+func (e *traceStoreExporter) convertAndSample(tid trace.TraceID, newSpans []sdktrace.ReadOnlySpan) (td *TraceData, keep bool, err error) {
+	if _, ok := e.sampler.(alwaysSampler); ok {
+		td, err = convertTrace(newSpans, e.spanLimits)
+		return td, true, err
+	}
+
+	e.pendingMu.Lock()
+	if decided, ok := e.decisions[tid]; ok {
+		e.pendingMu.Unlock()
+		if !decided {
+			return nil, false, nil
 		}
-		if err := e.store.Save(ctx, tid.String(), td); err != nil {
-			return err
+		td, err = convertTrace(newSpans, e.spanLimits)
+		return td, true, err
+	}
+	merged := append(e.pending[tid], newSpans...)
+	rootSeen := false
+	for _, span := range merged {
+		if !span.Parent().HasSpanID() {
+			rootSeen = true
+			break
 		}
 	}
-	return nil
+	if !rootSeen {
+		e.pending[tid] = merged
+		e.pendingMu.Unlock()
+		return nil, false, nil
+	}
+	delete(e.pending, tid)
+	e.pendingMu.Unlock()
+
+	td, err = convertTrace(merged, e.spanLimits)
+	if err != nil {
+		return nil, false, err
+	}
+	if root, ok := td.Spans[rootSpanID(td)]; ok {
+		keep = e.sampler.Sample(root, td)
+	} else {
+		keep = true
+	}
+
+	e.pendingMu.Lock()
+	e.decisions[tid] = keep
+	e.pendingMu.Unlock()
+
+	if !keep {
+		return nil, false, nil
+	}
+	return td, true, nil
+}
+
+// flushPending returns and clears every span currently buffered in
+// e.pending, grouped by trace ID. It is used by Shutdown to make sure
+// spans for traces whose root never arrived are not lost silently.
+func (e *traceStoreExporter) flushPending() map[trace.TraceID][]sdktrace.ReadOnlySpan {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+	pending := e.pending
+	e.pending = map[trace.TraceID][]sdktrace.ReadOnlySpan{}
+	return pending
+}
+
+// saveWithRetry calls e.store.Save, retrying failures that e.isRetryable
+// accepts with exponential backoff up to e.maxRetries times. A non-retryable
+// error is dead-lettered immediately, without consuming the backoff budget.
+// If every retry attempt fails, the trace is handed to e.deadLetterSink and
+// the final error is returned.
+func (e *traceStoreExporter) saveWithRetry(ctx context.Context, traceID string, td *TraceData) error {
+	backoff := e.initialBackoff
+	var err error
+	for attempt := 0; attempt <= e.maxRetries; attempt++ {
+		if err = e.store.Save(ctx, traceID, td); err == nil {
+			return nil
+		}
+		if !e.isRetryable(err) {
+			break
+		}
+		if attempt == e.maxRetries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			e.deadLetterSink.Put(ctx, traceID, td, ctx.Err())
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > e.maxBackoff {
+			backoff = e.maxBackoff
+		}
+	}
+	e.deadLetterSink.Put(ctx, traceID, td, err)
+	return err
 }
 
 // convertTrace converts a list of spans to a TraceData.
 // The spans must all have the same trace ID.
-func convertTrace(spans []sdktrace.ReadOnlySpan) (*TraceData, error) {
+func convertTrace(spans []sdktrace.ReadOnlySpan, limits SpanLimits) (*TraceData, error) {
 	td := &TraceData{Spans: map[string]*SpanData{}}
 	for _, span := range spans {
-		cspan := convertSpan(span)
+		cspan := convertSpan(span, limits)
 		// The unique span with no parent determines
 		// the TraceData fields.
 		if cspan.ParentSpanID == "" {
@@ -81,48 +347,176 @@ func convertTrace(spans []sdktrace.ReadOnlySpan) (*TraceData, error) {
 	return td, nil
 }
 
-// convertSpan converts an OpenTelemetry span to a SpanData.
-func convertSpan(span sdktrace.ReadOnlySpan) *SpanData {
+// rootSpanID returns the span ID of td's root span (the one with no
+// parent), or "" if none is found.
+func rootSpanID(td *TraceData) string {
+	for id, sd := range td.Spans {
+		if sd.ParentSpanID == "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// convertSpan converts an OpenTelemetry span to a SpanData, applying limits
+// to keep the result from growing unbounded for spans with large
+// attributes (e.g. LLM prompts/responses) or many events/links.
+func convertSpan(span sdktrace.ReadOnlySpan, limits SpanLimits) *SpanData {
 	sc := span.SpanContext()
+	var droppedAttrs, droppedEvents, droppedLinks int
+
+	attrs, n := truncateAttributes(span.Attributes(), limits)
+	droppedAttrs += n
+
+	links, n := convertLinks(span.Links(), limits)
+	droppedLinks += n + span.DroppedLinks()
+
+	events, n := convertEvents(span.Events(), limits)
+	droppedEvents += n + span.DroppedEvents()
+
+	droppedAttrs += span.DroppedAttributes()
+
 	sd := &SpanData{
-		SpanID:      sc.SpanID().String(),
-		TraceID:     sc.TraceID().String(),
-		StartTime:   timeToMicroseconds(span.StartTime()),
-		EndTime:     timeToMicroseconds(span.EndTime()),
-		Attributes:  attributesToMap(span.Attributes()),
-		DisplayName:  span.Name(),
-		Links:  convertLinks(span.Links()),
+		SpanID:                  sc.SpanID().String(),
+		TraceID:                 sc.TraceID().String(),
+		StartTime:               timeToMicroseconds(span.StartTime()),
+		EndTime:                 timeToMicroseconds(span.EndTime()),
+		Attributes:              attrs,
+		DisplayName:             span.Name(),
+		Links:                   links,
 		InstrumentationLibrary:  InstrumentationLibrary(span.InstrumentationLibrary()),
-		SpanKind:  span.SpanKind().String(),
+		SpanKind:                span.SpanKind().String(),
 		SameProcessAsParentSpan: boolValue{!sc.IsRemote()},
 		Status:                  convertStatus(span.Status()),
+		DroppedAttributesCount:  uint32(droppedAttrs),
+		DroppedEventsCount:      uint32(droppedEvents),
+		DroppedLinksCount:       uint32(droppedLinks),
 	}
 	if p := span.Parent(); p.HasSpanID() {
 		sd.ParentSpanID = p.SpanID().String()
 	}
-	sd.TimeEvents.TimeEvent = convertEvents(span.Events())
+	sd.TimeEvents.TimeEvent = events
+	if limits.MaxSpanBytes > 0 {
+		droppedAttrs += enforceMaxSpanBytes(sd, limits.MaxSpanBytes)
+	}
+	if droppedAttrs > 0 || droppedEvents > 0 || droppedLinks > 0 {
+		sd.DroppedAttributesCount = uint32(droppedAttrs)
+		sd.TimeEvents.TimeEvent = append(sd.TimeEvents.TimeEvent, droppedDataAnnotation(span, droppedAttrs, droppedEvents, droppedLinks))
+	}
 	return sd
 }
 
-func attributesToMap(attrs []attribute.KeyValue) map[string]any {
+// enforceMaxSpanBytes trims sd.Attributes, largest value first, until sd's
+// approximate serialized size is within maxBytes. It only accounts for the
+// span's name, IDs, and Attributes; TimeEvents and Links are bounded
+// separately by MaxEventsPerSpan/MaxLinksPerSpan. It returns the number of
+// attributes it dropped entirely to make room.
+func enforceMaxSpanBytes(sd *SpanData, maxBytes int) int {
+	dropped := 0
+	for approxSpanBytes(sd) > maxBytes {
+		k := largestAttributeKey(sd.Attributes)
+		if k == "" {
+			break
+		}
+		delete(sd.Attributes, k)
+		dropped++
+	}
+	return dropped
+}
+
+func approxSpanBytes(sd *SpanData) int {
+	n := len(sd.DisplayName) + len(sd.SpanID) + len(sd.TraceID)
+	for k, v := range sd.Attributes {
+		n += len(k) + len(fmt.Sprint(v))
+	}
+	return n
+}
+
+func largestAttributeKey(attrs map[string]any) string {
+	best := ""
+	bestLen := -1
+	for k, v := range attrs {
+		if l := len(fmt.Sprint(v)); l > bestLen {
+			best, bestLen = k, l
+		}
+	}
+	return best
+}
+
+// droppedDataAnnotation builds a synthetic TimeEvent noting how much of a
+// span's data was dropped, so the dev UI can surface it. The counts include
+// both drops made by SpanLimits and drops the OTel SDK reported on the span.
+func droppedDataAnnotation(span sdktrace.ReadOnlySpan, droppedAttrs, droppedEvents, droppedLinks int) TimeEvent {
+	return TimeEvent{
+		Time: timeToMicroseconds(span.EndTime()),
+		Annotation: annotation{
+			Description: "genkit: span data dropped or truncated",
+			Attributes: map[string]any{
+				"genkit:dropped_attributes_count": droppedAttrs,
+				"genkit:dropped_events_count":     droppedEvents,
+				"genkit:dropped_links_count":      droppedLinks,
+			},
+		},
+	}
+}
+
+// truncateValue shortens a string v to at most maxLen bytes, appending a
+// marker noting how many bytes were cut. Non-string values are returned
+// unchanged. A non-positive maxLen means no limit. The cut point is backed off to the nearest rune boundary
+// so the result stays valid UTF-8, even for multibyte prompt/response text.
+func truncateValue(v any, maxLen int) any {
+	if maxLen <= 0 {
+		return v
+	}
+	s, ok := v.(string)
+	if !ok || len(s) <= maxLen {
+		return v
+	}
+	cutAt := maxLen
+	for cutAt > 0 && !utf8.RuneStart(s[cutAt]) {
+		cutAt--
+	}
+	cut := len(s) - cutAt
+	return s[:cutAt] + fmt.Sprintf(truncationMarker, cut)
+}
+
+// truncateAttributes converts attrs to a map, truncating oversized values
+// and dropping attributes past limits.MaxAttributesPerSpan. It returns the
+// resulting map and the number of attributes dropped.
+func truncateAttributes(attrs []attribute.KeyValue, limits SpanLimits) (map[string]any, int) {
 	m := map[string]any{}
+	dropped := 0
 	for _, a := range attrs {
-		m[string(a.Key)] = a.Value.AsInterface()
+		if limits.MaxAttributesPerSpan > 0 && len(m) >= limits.MaxAttributesPerSpan {
+			dropped++
+			continue
+		}
+		m[string(a.Key)] = truncateValue(a.Value.AsInterface(), limits.MaxAttributeValueLength)
 	}
-	return m
+	return m, dropped
 }
 
-func convertLinks(links []sdktrace.Link) []*Link {
+// convertLinks converts links to their SpanData representation, dropping
+// links past limits.MaxLinksPerSpan. It returns the resulting links and
+// the number of links dropped by the limit (span-reported drops are not
+// included; callers add span.DroppedLinks() separately).
+func convertLinks(links []sdktrace.Link, limits SpanLimits) ([]*Link, int) {
 	var cls []*Link
+	dropped := 0
 	for _, l := range links {
+		if limits.MaxLinksPerSpan > 0 && len(cls) >= limits.MaxLinksPerSpan {
+			dropped++
+			continue
+		}
+		attrs, n := truncateAttributes(l.Attributes, limits)
 		cl := &Link{
 			SpanContext:            convertSpanContext(l.SpanContext),
-			Attributes:             attributesToMap(l.Attributes),
-			DroppedAttributesCount: l.DroppedAttributeCount,
+			Attributes:             attrs,
+			DroppedAttributesCount: l.DroppedAttributeCount + uint32(n),
 		}
 		cls = append(cls, cl)
 	}
-	return cls
+	return cls, dropped
 }
 
 func convertSpanContext(sc trace.SpanContext) SpanContext {
@@ -134,18 +528,28 @@ func convertSpanContext(sc trace.SpanContext) SpanContext {
 	}
 }
 
-func convertEvents(evs []sdktrace.Event) []TimeEvent {
+// convertEvents converts evs to their SpanData representation, dropping
+// events past limits.MaxEventsPerSpan. It returns the resulting events and
+// the number of events dropped by the limit (span-reported drops are not
+// included; callers add span.DroppedEvents() separately).
+func convertEvents(evs []sdktrace.Event, limits SpanLimits) ([]TimeEvent, int) {
 	var tes []TimeEvent
+	dropped := 0
 	for _, e := range evs {
+		if limits.MaxEventsPerSpan > 0 && len(tes) >= limits.MaxEventsPerSpan {
+			dropped++
+			continue
+		}
+		attrs, _ := truncateAttributes(e.Attributes, limits)
 		tes = append(tes, TimeEvent{
 			Time: timeToMicroseconds(e.Time),
 			Annotation: annotation{
 				Description: e.Name,
-				Attributes:  attributesToMap(e.Attributes),
+				Attributes:  attrs,
 			},
 		})
 	}
-	return tes
+	return tes, dropped
 }
 
 func convertStatus(s sdktrace.Status) Status {
@@ -155,5 +559,23 @@ func convertStatus(s sdktrace.Status) Status {
 	}
 }
 
-// ExportSpans implements [go.opentelemetry.io/otel/sdk/trace.SpanExporter.Shutdown].
-func (e *traceStoreExporter) Shutdown(ctx context.Context) error { return nil }
\ No newline at end of file
+// Shutdown implements [go.opentelemetry.io/otel/sdk/trace.SpanExporter.Shutdown].
+// Any spans still buffered waiting for a root span that never arrived
+// (e.g. a flow that crashed before its root span completed) are saved
+// as-is rather than dropped; since no root span exists to key e.sampler
+// on, these orphaned subtrees bypass sampling but are still redacted.
+func (e *traceStoreExporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for tid, spans := range e.flushPending() {
+		td, err := convertTrace(spans, e.spanLimits)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		redactTrace(td, e.redactor)
+		if err := e.saveWithRetry(ctx, tid.String(), td); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
\ No newline at end of file